@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+const (
+	poolIdleTimeout   = 90 * time.Second
+	poolMaxPerKey     = 4
+	poolSweepInterval = 30 * time.Second
+)
+
+// pooledConn is a previously-handshaken uTLS connection kept around for
+// reuse by a later request that hashes to the same key.
+type pooledConn struct {
+	conn     *utls.UConn
+	lastUsed time.Time
+}
+
+// connPool reuses established uTLS connections across requests in daemon
+// mode, keyed by host:port + fingerprint hash + proxy, so repeated requests
+// to the same target skip DNS/TCP/TLS entirely.
+type connPool struct {
+	mu    sync.Mutex
+	conns map[string][]*pooledConn
+	stop  chan struct{}
+}
+
+func newConnPool() *connPool {
+	p := &connPool{conns: make(map[string][]*pooledConn), stop: make(chan struct{})}
+	go p.sweepLoop()
+	return p
+}
+
+func (p *connPool) sweepLoop() {
+	ticker := time.NewTicker(poolSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.evictIdle()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+func (p *connPool) evictIdle() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, conns := range p.conns {
+		fresh := conns[:0]
+		for _, c := range conns {
+			if time.Since(c.lastUsed) > poolIdleTimeout {
+				c.conn.Close()
+				continue
+			}
+			fresh = append(fresh, c)
+		}
+		if len(fresh) == 0 {
+			delete(p.conns, key)
+		} else {
+			p.conns[key] = fresh
+		}
+	}
+}
+
+// take returns a pooled connection for key, or nil if none is available.
+func (p *connPool) take(key string) *utls.UConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	conns := p.conns[key]
+	if len(conns) == 0 {
+		return nil
+	}
+	last := conns[len(conns)-1]
+	p.conns[key] = conns[:len(conns)-1]
+	return last.conn
+}
+
+// put returns conn to the pool for reuse, closing it instead if key already
+// holds poolMaxPerKey connections.
+func (p *connPool) put(key string, conn *utls.UConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.conns[key]) >= poolMaxPerKey {
+		conn.Close()
+		return
+	}
+	p.conns[key] = append(p.conns[key], &pooledConn{conn: conn, lastUsed: time.Now()})
+}
+
+func (p *connPool) closeAll() {
+	close(p.stop)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conns := range p.conns {
+		for _, c := range conns {
+			c.conn.Close()
+		}
+	}
+	p.conns = nil
+}
+
+// poolKey identifies connections that are safe to share: same destination,
+// same TLS fingerprint, same proxy.
+func poolKey(addr string, fp *FingerprintConfig, proxyURL string) string {
+	fpJSON, _ := json.Marshal(fp)
+	sum := sha256.Sum256(fpJSON)
+	return fmt.Sprintf("%s|%x|%s", addr, sum[:8], proxyURL)
+}
+
+// runDaemon turns the process into a persistent worker: it keeps reading
+// newline-delimited JSON requests off dec and writes a 4-byte big-endian
+// length prefix followed by the response for each one to stdout, reusing
+// pooled connections so repeated requests skip the TLS handshake. It never
+// exits on a single request's error - only on EOF or malformed framing.
+func runDaemon(dec *json.Decoder, firstRaw json.RawMessage, firstReq *Request, cfg *TLSConfig) {
+	pool := newConnPool()
+	defer pool.closeAll()
+
+	handleDaemonRequest(firstRaw, firstReq, cfg, pool)
+
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return
+		}
+		var req Request
+		if err := json.Unmarshal(raw, &req); err != nil {
+			writeFramed(daemonErrorBody("invalid request JSON: " + err.Error()))
+			continue
+		}
+		handleDaemonRequest(raw, &req, cfg, pool)
+	}
+}
+
+func handleDaemonRequest(raw json.RawMessage, req *Request, cfg *TLSConfig, pool *connPool) {
+	var buf bytes.Buffer
+	if err := executeRequest(raw, req, cfg, pool, &buf); err != nil {
+		writeFramed(daemonErrorBody(err.Error()))
+		return
+	}
+	writeFramed(buf.Bytes())
+}
+
+func daemonErrorBody(msg string) []byte {
+	j, _ := json.Marshal(map[string]string{"error": msg})
+	return j
+}
+
+func writeFramed(body []byte) {
+	var lenPrefix [4]byte
+	binary.BigEndian.PutUint32(lenPrefix[:], uint32(len(body)))
+	os.Stdout.Write(lenPrefix[:])
+	os.Stdout.Write(body)
+}
+
+// executeRequest is the daemon's per-request path: it's the pooled,
+// error-returning counterpart to runOneshot, which instead exits the
+// process via fatal() since it only ever serves one request.
+func executeRequest(raw json.RawMessage, req *Request, cfg *TLSConfig, pool *connPool, w io.Writer) error {
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+
+	host := u.Hostname()
+	port := u.Port()
+	if port == "" {
+		if u.Scheme == "https" {
+			port = "443"
+		} else {
+			port = "80"
+		}
+	}
+	addr := net.JoinHostPort(host, port)
+
+	spec, err := resolveFingerprintSpec(&cfg.Fingerprint, host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve fingerprint: %w", err)
+	}
+
+	connectTimeout := time.Duration(req.Timeout.Connect) * time.Second
+	if connectTimeout == 0 {
+		connectTimeout = 30 * time.Second
+	}
+	readTimeout := time.Duration(req.Timeout.Read) * time.Second
+	if readTimeout == 0 {
+		readTimeout = 120 * time.Second
+	}
+
+	proxyCfg, err := resolveProxy(req, cfg, req.URL)
+	if err != nil {
+		return fmt.Errorf("failed to resolve proxy: %w", err)
+	}
+
+	if req.Transport == "quic" {
+		// QUIC requests are not pooled: sendQUICRequest dials its own UDP
+		// socket and QUIC connection per call and closes them on return.
+		return sendQUICRequest(req, spec, &cfg.QUICFingerprint, host, addr, parseOrderedHeaders(raw), u, connectTimeout, readTimeout, w, proxyCfg)
+	}
+
+	proxyKeyPart := ""
+	if proxyCfg != nil {
+		if j, err := json.Marshal(proxyCfg); err == nil {
+			proxyKeyPart = string(j)
+		}
+	}
+	key := poolKey(addr, &cfg.Fingerprint, proxyKeyPart)
+
+	tlsConn := pool.take(key)
+	reused := tlsConn != nil
+	if tlsConn == nil {
+		tlsConn, err = dialAndHandshake(cfg, proxyCfg, &spec, host, addr, connectTimeout)
+		if err != nil {
+			return err
+		}
+	}
+
+	orderedHeaders := parseOrderedHeaders(raw)
+
+	// A pooled connection can go stale between requests - the peer is free
+	// to close an idle HTTP keep-alive connection well before
+	// poolIdleTimeout elapses, which is routine rather than exceptional -
+	// so a reused connection's first failure gets one fresh-dial retry
+	// before giving up. A connection that was just freshly dialed has no
+	// such excuse, so its failure is reported as-is. The response is
+	// buffered rather than written straight to w so a failed first attempt
+	// on a reused connection can't leave partial output ahead of the retry.
+	var respBuf bytes.Buffer
+	err = sendOverConn(tlsConn, cfg, req, orderedHeaders, u, readTimeout, &respBuf)
+	if err != nil && reused {
+		tlsConn.Close()
+		tlsConn, err = dialAndHandshake(cfg, proxyCfg, &spec, host, addr, connectTimeout)
+		if err != nil {
+			return err
+		}
+		respBuf.Reset()
+		err = sendOverConn(tlsConn, cfg, req, orderedHeaders, u, readTimeout, &respBuf)
+	}
+	if err != nil {
+		tlsConn.Close()
+		return fmt.Errorf("request failed: %w", err)
+	}
+
+	if _, err := respBuf.WriteTo(w); err != nil {
+		return fmt.Errorf("failed to write response: %w", err)
+	}
+
+	pool.put(key, tlsConn)
+	return nil
+}
+
+// dialAndHandshake dials addr (through ShadowTLS, a proxy, or directly, per
+// cfg) and performs the uTLS handshake for spec, retrying once with the
+// server's ECH retry_configs if the handshake signals ech_required. It's
+// the pooled counterpart of the fresh-dial path runOneshot (main.go) runs
+// inline, factored out here so executeRequest's stale-connection retry
+// doesn't need a third copy of this dial/handshake/ECH-retry sequence.
+func dialAndHandshake(cfg *TLSConfig, proxyCfg *ProxyConfig, spec *utls.ClientHelloSpec, host, addr string, connectTimeout time.Duration) (*utls.UConn, error) {
+	rawConn, err := dialForFingerprint(cfg, proxyCfg, *spec, addr, connectTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("connection failed: %w", err)
+	}
+
+	tlsConfig := &utls.Config{ServerName: host, InsecureSkipVerify: false}
+	if cfg.Fingerprint.HTTP2 {
+		tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+	}
+	tlsConn := utls.UClient(rawConn, tlsConfig, utls.HelloCustom)
+	if err := tlsConn.ApplyPreset(spec); err != nil {
+		return nil, fmt.Errorf("failed to apply TLS preset: %w", err)
+	}
+	tlsConn.SetDeadline(time.Now().Add(connectTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		retryList, isECHRequired := echRetryConfigs(err)
+		if !isECHRequired {
+			return nil, fmt.Errorf("TLS handshake failed: %w", err)
+		}
+		if applyErr := applyECHRetryConfigs(spec, retryList, host); applyErr != nil {
+			return nil, fmt.Errorf("TLS handshake failed: %w", err)
+		}
+
+		rawConn.Close()
+		rawConn, err = dialForFingerprint(cfg, proxyCfg, *spec, addr, connectTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("connection failed after ECH retry: %w", err)
+		}
+
+		tlsConn = utls.UClient(rawConn, tlsConfig, utls.HelloCustom)
+		if err := tlsConn.ApplyPreset(spec); err != nil {
+			return nil, fmt.Errorf("failed to apply TLS preset after ECH retry: %w", err)
+		}
+		tlsConn.SetDeadline(time.Now().Add(connectTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, fmt.Errorf("TLS handshake failed after ECH retry: %w", err)
+		}
+	}
+
+	return tlsConn, nil
+}
+
+// dialForFingerprint picks the raw net.Conn path a handshake runs over:
+// ShadowTLS's decoy handshake, a configured proxy, or a direct dial through
+// the same custom-DNS-aware dialer runOneshot uses, so cfg.DNS.Servers
+// applies the same way in daemon mode as it does in oneshot mode.
+func dialForFingerprint(cfg *TLSConfig, proxyCfg *ProxyConfig, spec utls.ClientHelloSpec, addr string, connectTimeout time.Duration) (net.Conn, error) {
+	switch {
+	case cfg.ShadowTLS.Enabled:
+		return dialShadowTLS(&cfg.ShadowTLS, spec, connectTimeout)
+	case proxyCfg != nil:
+		return dialViaProxy(proxyCfg, addr, connectTimeout)
+	default:
+		return newFingerprintDialer(cfg, connectTimeout).Dial("tcp", addr)
+	}
+}
+
+// sendOverConn issues req over an already-handshaken tlsConn, choosing the
+// HTTP/2 or HTTP/1.1 path the same way runOneshot does inline.
+func sendOverConn(tlsConn *utls.UConn, cfg *TLSConfig, req *Request, orderedHeaders [][2]string, u *url.URL, readTimeout time.Duration, w io.Writer) error {
+	tlsConn.SetDeadline(time.Now().Add(readTimeout))
+	if cfg.Fingerprint.HTTP2 && tlsConn.ConnectionState().NegotiatedProtocol == "h2" {
+		return sendHTTP2Request(tlsConn, req, &cfg.Fingerprint.HTTP2Settings, orderedHeaders, u, w)
+	}
+	return sendHTTP1Request(tlsConn, req, orderedHeaders, u, w)
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// ── browser presets ──
+
+// presetMap maps human-friendly fingerprint names to uTLS's built-in
+// ClientHelloID values, so tls_config.json can say {"preset": "chrome_120"}
+// instead of spelling out every cipher/extension/curve by hand.
+var presetMap = map[string]utls.ClientHelloID{
+	"chrome_120":         utls.HelloChrome_120,
+	"chrome_112":         utls.HelloChrome_112,
+	"chrome_106_shuffle": utls.HelloChrome_106_Shuffle,
+	"firefox_105":        utls.HelloFirefox_105,
+	"firefox_120":        utls.HelloFirefox_120,
+	"safari_16_0":        utls.HelloSafari_16_0,
+	"ios_14":             utls.HelloIOS_14,
+	"edge_106":           utls.HelloEdge_106,
+}
+
+// presetAliasRejected names presets that were once silently mapped onto a
+// neighboring version's ClientHelloID (e.g. "firefox_115" onto
+// HelloFirefox_105) because uTLS has no distinct entry for that version.
+// Serving a substitute fingerprint under a different name is a detectable
+// mismatch - a server comparing the claimed version against the actual
+// handshake would see "firefox_115" arrive looking exactly like
+// HelloFirefox_105 - so these now fail closed instead.
+var presetAliasRejected = map[string]string{
+	"firefox_115": "firefox_105",
+	"safari_17":   "safari_16_0",
+}
+
+// specForPreset resolves a named browser preset to a ClientHelloSpec via
+// uTLS's own fingerprint database, so the preset path always stays in sync
+// with whatever uTLS ships for that browser/version.
+func specForPreset(name string) (utls.ClientHelloSpec, error) {
+	name = strings.ToLower(name)
+	if nearest, rejected := presetAliasRejected[name]; rejected {
+		return utls.ClientHelloSpec{}, fmt.Errorf("fingerprint preset %q has no distinct uTLS ClientHelloID; use %q explicitly if the %q fingerprint is an acceptable substitute", name, nearest, nearest)
+	}
+	id, ok := presetMap[name]
+	if !ok {
+		return utls.ClientHelloSpec{}, fmt.Errorf("unknown fingerprint preset: %s", name)
+	}
+	return utls.UTLSIdToSpec(id)
+}
+
+// ── JA3 ──
+
+// parseJA3 parses a JA3 fingerprint string of the form
+// "TLSVersion,Ciphers,Extensions,EllipticCurves,ECPointFormats" (each field
+// dash-separated) into a ClientHelloSpec. JA3 only records extension IDs,
+// not their payloads, so each extension is reconstructed in its default
+// form and filled in from the rest of the fingerprint (serverName, curves).
+func parseJA3(ja3, serverName string) (utls.ClientHelloSpec, error) {
+	fields := strings.Split(ja3, ",")
+	if len(fields) != 5 {
+		return utls.ClientHelloSpec{}, fmt.Errorf("malformed JA3 string: expected 5 comma-separated fields, got %d", len(fields))
+	}
+
+	version, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return utls.ClientHelloSpec{}, fmt.Errorf("invalid JA3 TLS version %q: %w", fields[0], err)
+	}
+
+	cipherIDs := ja3IDs(fields[1])
+	extIDs := ja3IDs(fields[2])
+	curveIDs := ja3IDs(fields[3])
+	pointFormatIDs := ja3IDs(fields[4])
+
+	cipherSuites := make([]uint16, len(cipherIDs))
+	for i, c := range cipherIDs {
+		cipherSuites[i] = uint16(c)
+	}
+
+	curves := make([]utls.CurveID, len(curveIDs))
+	for i, c := range curveIDs {
+		curves[i] = utls.CurveID(c)
+	}
+
+	pointFormats := make([]byte, len(pointFormatIDs))
+	for i, p := range pointFormatIDs {
+		pointFormats[i] = byte(p)
+	}
+
+	var extensions []utls.TLSExtension
+	for _, id := range extIDs {
+		extensions = append(extensions, ja3ExtensionByID(uint16(id), serverName, curves, pointFormats))
+	}
+
+	return utls.ClientHelloSpec{
+		TLSVersMin:         uint16(version),
+		TLSVersMax:         uint16(version),
+		CipherSuites:       cipherSuites,
+		CompressionMethods: []uint8{0},
+		Extensions:         extensions,
+	}, nil
+}
+
+// ja3IDs splits a dash-separated JA3 field into its integer components. An
+// empty field is valid JA3 and means "none offered" (e.g. no curves).
+func ja3IDs(field string) []int {
+	if field == "" {
+		return nil
+	}
+	parts := strings.Split(field, "-")
+	ids := make([]int, 0, len(parts))
+	for _, p := range parts {
+		if n, err := strconv.Atoi(p); err == nil {
+			ids = append(ids, n)
+		}
+	}
+	return ids
+}
+
+// ja3ExtensionByID maps a raw TLS extension ID to its uTLS extension type.
+// Unrecognized IDs fall back to an empty GenericExtension so the cipher/
+// extension counts a server sees still line up with the JA3 string.
+func ja3ExtensionByID(id uint16, serverName string, curves []utls.CurveID, pointFormats []byte) utls.TLSExtension {
+	switch id {
+	case 0:
+		return &utls.SNIExtension{ServerName: serverName}
+	case 5:
+		return &utls.StatusRequestExtension{}
+	case 10:
+		return &utls.SupportedCurvesExtension{Curves: curves}
+	case 11:
+		return &utls.SupportedPointsExtension{SupportedPoints: pointFormats}
+	case 13:
+		return &utls.SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: defaultSigAlgs}
+	case 16:
+		return &utls.ALPNExtension{AlpnProtocols: []string{"h2", "http/1.1"}}
+	case 18:
+		return &utls.SCTExtension{}
+	case 21:
+		return &utls.UtlsPaddingExtension{GetPaddingLen: utls.BoringPaddingStyle}
+	case 23:
+		return &utls.ExtendedMasterSecretExtension{}
+	case 35:
+		return &utls.SessionTicketExtension{}
+	case 43:
+		return &utls.SupportedVersionsExtension{Versions: []uint16{tls.VersionTLS13, tls.VersionTLS12}}
+	case 45:
+		return &utls.PSKKeyExchangeModesExtension{Modes: []uint8{utls.PskModeDHE}}
+	case 51:
+		var keyShares []utls.KeyShare
+		for _, c := range curves {
+			keyShares = append(keyShares, utls.KeyShare{Group: c})
+		}
+		return &utls.KeyShareExtension{KeyShares: keyShares}
+	case 65281:
+		return &utls.RenegotiationInfoExtension{Renegotiation: utls.RenegotiateOnceAsClient}
+	default:
+		return &utls.GenericExtension{Id: id}
+	}
+}
+
+var defaultSigAlgs = []utls.SignatureScheme{
+	utls.ECDSAWithP256AndSHA256,
+	utls.PSSWithSHA256,
+	utls.PKCS1WithSHA256,
+	utls.ECDSAWithP384AndSHA384,
+	utls.PSSWithSHA384,
+	utls.PKCS1WithSHA384,
+	utls.PSSWithSHA512,
+	utls.PKCS1WithSHA512,
+}
+
+// ── JA4 ──
+
+// ja4Fingerprint is a bundled reconstruction of everything JA4 doesn't
+// encode directly: JA4 only carries counts plus two truncated-SHA256
+// hashes, so recovering exact extension order and signature algorithms
+// requires matching those hashes against a table built from known captures.
+type ja4Fingerprint struct {
+	Ciphers    []string
+	Extensions []string
+	SigAlgs    []string
+}
+
+// ja4Table maps "<ciphers-hash>_<extension+sigalg-hash>" (the two trailing
+// segments of a JA4 string) to the full fingerprint it was computed from.
+// An unrecognized pair fails closed rather than guessing an ordering.
+//
+// This is a small, hand-seeded table - today it only covers the Chrome 120
+// desktop fingerprint below - not a general JA4 database. JA4's two hash
+// segments aren't reversible, so every additional entry has to be captured
+// from a real handshake and added by hand; there's no way to synthesize
+// coverage for a fingerprint nobody has recorded yet. Expand this table as
+// fingerprints are captured; callers hitting "not in bundled table" need a
+// new entry added here, not a workaround at the call site.
+var ja4Table = map[string]ja4Fingerprint{
+	"8daaf6152771_02713d6af862": {
+		Ciphers: []string{
+			"TLS_AES_128_GCM_SHA256", "TLS_AES_256_GCM_SHA384", "TLS_CHACHA20_POLY1305_SHA256",
+			"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+			"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+			"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256", "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+		},
+		Extensions: []string{
+			"server_name", "extended_master_secret", "renegotiation_info", "supported_groups",
+			"ec_point_formats", "session_ticket", "application_layer_protocol_negotiation",
+			"status_request", "signature_algorithms", "signed_certificate_timestamp",
+			"key_share", "psk_key_exchange_modes", "supported_versions",
+		},
+		SigAlgs: []string{
+			"ECDSAWithP256AndSHA256", "PSSWithSHA256", "PKCS1WithSHA256",
+			"ECDSAWithP384AndSHA384", "PSSWithSHA384", "PKCS1WithSHA384",
+			"PSSWithSHA512", "PKCS1WithSHA512",
+		},
+	},
+}
+
+// parseJA4 parses the structured head of a JA4 string
+// (protocol/version/SNI-or-IP/cipher-count/ext-count/ALPN) and resolves the
+// two trailing hash segments against ja4Table to recover extension order
+// and signature algorithms.
+func parseJA4(ja4, serverName string) (utls.ClientHelloSpec, error) {
+	parts := strings.SplitN(ja4, "_", 3)
+	if len(parts) != 3 {
+		return utls.ClientHelloSpec{}, fmt.Errorf("malformed JA4 string: expected 3 underscore-separated segments")
+	}
+	head, cipherHash, extHash := parts[0], parts[1], parts[2]
+	if len(head) < 10 {
+		return utls.ClientHelloSpec{}, fmt.Errorf("malformed JA4 header segment: %q", head)
+	}
+
+	// head layout: q|t (protocol), 2-digit version, d|i (SNI or IP),
+	// 2-digit cipher count, 2-digit extension count, 2-char ALPN.
+	minVer, maxVer := uint16(tls.VersionTLS12), uint16(tls.VersionTLS13)
+	switch head[1:3] {
+	case "12":
+		minVer, maxVer = tls.VersionTLS12, tls.VersionTLS12
+	case "13":
+		minVer, maxVer = tls.VersionTLS12, tls.VersionTLS13
+	}
+
+	known, ok := ja4Table[cipherHash+"_"+extHash]
+	if !ok {
+		return utls.ClientHelloSpec{}, fmt.Errorf("unrecognized JA4 fingerprint %s_%s: not in bundled table (ja4Table only covers %d captured fingerprint(s) today; add this one to ja4Table once it's been captured from a real handshake)", cipherHash, extHash, len(ja4Table))
+	}
+
+	var cipherSuites []uint16
+	for _, name := range known.Ciphers {
+		if id, ok := cipherMap[name]; ok {
+			cipherSuites = append(cipherSuites, id)
+		}
+	}
+
+	curves := []utls.CurveID{utls.X25519, utls.CurveP256, utls.CurveP384}
+
+	var sigAlgs []utls.SignatureScheme
+	for _, name := range known.SigAlgs {
+		if id, ok := sigAlgMap[name]; ok {
+			sigAlgs = append(sigAlgs, id)
+		}
+	}
+
+	var extensions []utls.TLSExtension
+	for _, name := range known.Extensions {
+		extensions = append(extensions, ja4ExtensionByName(name, serverName, curves, sigAlgs))
+	}
+
+	return utls.ClientHelloSpec{
+		TLSVersMin:         minVer,
+		TLSVersMax:         maxVer,
+		CipherSuites:       cipherSuites,
+		CompressionMethods: []uint8{0},
+		Extensions:         extensions,
+	}, nil
+}
+
+func ja4ExtensionByName(name, serverName string, curves []utls.CurveID, sigAlgs []utls.SignatureScheme) utls.TLSExtension {
+	switch name {
+	case "server_name":
+		return &utls.SNIExtension{ServerName: serverName}
+	case "extended_master_secret":
+		return &utls.ExtendedMasterSecretExtension{}
+	case "renegotiation_info":
+		return &utls.RenegotiationInfoExtension{Renegotiation: utls.RenegotiateOnceAsClient}
+	case "supported_groups":
+		return &utls.SupportedCurvesExtension{Curves: curves}
+	case "ec_point_formats":
+		return &utls.SupportedPointsExtension{SupportedPoints: []byte{0}}
+	case "session_ticket":
+		return &utls.SessionTicketExtension{}
+	case "application_layer_protocol_negotiation":
+		return &utls.ALPNExtension{AlpnProtocols: []string{"h2", "http/1.1"}}
+	case "status_request":
+		return &utls.StatusRequestExtension{}
+	case "signature_algorithms":
+		return &utls.SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: sigAlgs}
+	case "signed_certificate_timestamp":
+		return &utls.SCTExtension{}
+	case "key_share":
+		var keyShares []utls.KeyShare
+		for _, c := range curves {
+			keyShares = append(keyShares, utls.KeyShare{Group: c})
+		}
+		return &utls.KeyShareExtension{KeyShares: keyShares}
+	case "psk_key_exchange_modes":
+		return &utls.PSKKeyExchangeModesExtension{Modes: []uint8{utls.PskModeDHE}}
+	case "supported_versions":
+		return &utls.SupportedVersionsExtension{Versions: []uint16{tls.VersionTLS13, tls.VersionTLS12}}
+	default:
+		return &utls.GenericExtension{}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// defaultPseudoHeaderOrder is the pseudo-header order Chrome-family
+// browsers send; callers can override it via HTTP2Config.PseudoHeaderOrder.
+var defaultPseudoHeaderOrder = []string{":method", ":authority", ":scheme", ":path"}
+
+// sendHTTP2Request drives an h2 connection by hand on top of the already
+// ALPN-negotiated tlsConn: it writes the client preface and a browser-shaped
+// SETTINGS/WINDOW_UPDATE/PRIORITY preamble, sends the request as
+// HEADERS(+DATA), reads the response frames back, and reserializes them to
+// w in the same wire format the HTTP/1.1 path uses.
+func sendHTTP2Request(tlsConn io.ReadWriter, req *Request, h2cfg *HTTP2Config, orderedHeaders [][2]string, u *url.URL, w io.Writer) error {
+	if _, err := io.WriteString(tlsConn, http2.ClientPreface); err != nil {
+		return fmt.Errorf("failed to write client preface: %w", err)
+	}
+
+	framer := http2.NewFramer(tlsConn, tlsConn)
+	framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+
+	settings := buildSettings(h2cfg)
+	if err := framer.WriteSettings(settings...); err != nil {
+		return fmt.Errorf("failed to write SETTINGS: %w", err)
+	}
+
+	connWindow := h2cfg.ConnectionWindowSize
+	if connWindow == 0 {
+		connWindow = 15663105 // Chrome's default connection-level WINDOW_UPDATE increment
+	}
+	if err := framer.WriteWindowUpdate(0, connWindow); err != nil {
+		return fmt.Errorf("failed to write connection WINDOW_UPDATE: %w", err)
+	}
+
+	const streamID = 1
+
+	if h2cfg.PriorityStreamDep != 0 || h2cfg.PriorityWeight != 0 {
+		if err := framer.WritePriority(streamID, http2.PriorityParam{
+			StreamDep: h2cfg.PriorityStreamDep,
+			Exclusive: h2cfg.PriorityExclusive,
+			Weight:    h2cfg.PriorityWeight,
+		}); err != nil {
+			return fmt.Errorf("failed to write PRIORITY: %w", err)
+		}
+	}
+
+	headerBlock, err := encodeHeaders(req, h2cfg, orderedHeaders, u)
+	if err != nil {
+		return fmt.Errorf("failed to encode headers: %w", err)
+	}
+
+	err = framer.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      streamID,
+		BlockFragment: headerBlock,
+		EndHeaders:    true,
+		EndStream:     req.Body == "",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write HEADERS: %w", err)
+	}
+
+	if req.Body != "" {
+		if err := framer.WriteData(streamID, true, []byte(req.Body)); err != nil {
+			return fmt.Errorf("failed to write DATA: %w", err)
+		}
+	}
+
+	return readHTTP2Response(framer, streamID, w)
+}
+
+// buildSettings assembles the client SETTINGS frame in the order browsers
+// use, skipping any configurable value the caller didn't set so the
+// fingerprint can omit entries entirely rather than sending a zero.
+// ENABLE_PUSH is not configurable and is always sent as 0.
+func buildSettings(h2cfg *HTTP2Config) []http2.Setting {
+	var settings []http2.Setting
+	if h2cfg.HeaderTableSize != nil {
+		settings = append(settings, http2.Setting{ID: http2.SettingHeaderTableSize, Val: *h2cfg.HeaderTableSize})
+	}
+	settings = append(settings, http2.Setting{ID: http2.SettingEnablePush, Val: 0})
+	if h2cfg.MaxConcurrentStreams != nil {
+		settings = append(settings, http2.Setting{ID: http2.SettingMaxConcurrentStreams, Val: *h2cfg.MaxConcurrentStreams})
+	}
+	if h2cfg.InitialWindowSize != nil {
+		settings = append(settings, http2.Setting{ID: http2.SettingInitialWindowSize, Val: *h2cfg.InitialWindowSize})
+	}
+	if h2cfg.MaxHeaderListSize != nil {
+		settings = append(settings, http2.Setting{ID: http2.SettingMaxHeaderListSize, Val: *h2cfg.MaxHeaderListSize})
+	}
+	return settings
+}
+
+// encodeHeaders HPACK-encodes the pseudo-headers (in the caller-configured
+// order) followed by the regular headers in the order the caller sent them.
+func encodeHeaders(req *Request, h2cfg *HTTP2Config, orderedHeaders [][2]string, u *url.URL) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := hpack.NewEncoder(&buf)
+
+	pseudo := map[string]string{
+		":method":    strings.ToUpper(req.Method),
+		":authority": u.Host,
+		":scheme":    u.Scheme,
+		":path":      u.RequestURI(),
+	}
+
+	order := h2cfg.PseudoHeaderOrder
+	if len(order) == 0 {
+		order = defaultPseudoHeaderOrder
+	}
+	for _, name := range order {
+		val, ok := pseudo[name]
+		if !ok {
+			continue
+		}
+		if err := enc.WriteField(hpack.HeaderField{Name: name, Value: val}); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, kv := range orderedHeaders {
+		name := strings.ToLower(kv[0])
+		if name == "host" || name == "connection" {
+			continue // not valid in h2; :authority/frame-level flow control replace them
+		}
+		if err := enc.WriteField(hpack.HeaderField{Name: name, Value: kv[1]}); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// readHTTP2Response reads frames for streamID until END_STREAM, writing the
+// response in the same "status line + headers + blank line + body" format
+// the HTTP/1.1 path produces so callers don't need to change.
+func readHTTP2Response(framer *http2.Framer, streamID uint32, w io.Writer) error {
+	var status string
+	headerLines := []string{}
+	var body bytes.Buffer
+
+	for {
+		frame, err := framer.ReadFrame()
+		if err != nil {
+			return fmt.Errorf("failed to read frame: %w", err)
+		}
+
+		switch f := frame.(type) {
+		case *http2.SettingsFrame:
+			if !f.IsAck() {
+				if err := framer.WriteSettingsAck(); err != nil {
+					return fmt.Errorf("failed to ack SETTINGS: %w", err)
+				}
+			}
+
+		case *http2.MetaHeadersFrame:
+			if f.StreamID != streamID {
+				continue
+			}
+			for _, hf := range f.Fields {
+				if hf.Name == ":status" {
+					status = hf.Value
+					continue
+				}
+				headerLines = append(headerLines, hf.Name+": "+hf.Value)
+			}
+			if f.StreamEnded() {
+				return writeHTTP2Response(w, status, headerLines, body.Bytes())
+			}
+
+		case *http2.DataFrame:
+			if f.StreamID != streamID {
+				continue
+			}
+			body.Write(f.Data())
+			if f.StreamEnded() {
+				return writeHTTP2Response(w, status, headerLines, body.Bytes())
+			}
+
+		case *http2.WindowUpdateFrame, *http2.PingFrame, *http2.GoAwayFrame:
+			// Not meaningful for a single short-lived request; ignore.
+
+		case *http2.RSTStreamFrame:
+			if f.StreamID == streamID {
+				return fmt.Errorf("stream reset by server: %s", f.ErrCode)
+			}
+		}
+	}
+}
+
+func writeHTTP2Response(w io.Writer, status string, headerLines []string, body []byte) error {
+	code := status
+	if code == "" {
+		code = "200"
+	}
+	io.WriteString(w, "HTTP/2 "+code+"\r\n")
+	for _, line := range headerLines {
+		io.WriteString(w, line+"\r\n")
+	}
+	io.WriteString(w, "\r\n")
+	_, err := w.Write(body)
+	return err
+}
@@ -14,7 +14,6 @@ import (
 	"time"
 
 	utls "github.com/refraction-networking/utls"
-	"golang.org/x/net/proxy"
 )
 
 // ── stdin request ──
@@ -25,9 +24,19 @@ type TimeoutConfig struct {
 }
 
 type ProxyConfig struct {
-	Enabled bool   `json:"enabled"`
-	Type    string `json:"type"`
-	URL     string `json:"url"`
+	Enabled bool       `json:"enabled"`
+	Type    string     `json:"type"`
+	URL     string     `json:"url"`
+	Chain   []ProxyHop `json:"chain,omitempty"`
+	PACURL  string     `json:"pac_url,omitempty"`
+}
+
+// ProxyHop is one link of a chained proxy: each hop tunnels through the
+// previous one (or connects directly, for the first hop) before the last
+// hop issues the CONNECT to the real target.
+type ProxyHop struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
 }
 
 type Request struct {
@@ -38,36 +47,56 @@ type Request struct {
 	ConfigPath string            `json:"config_path"`
 	Timeout    TimeoutConfig     `json:"timeout"`
 	Proxy      *ProxyConfig      `json:"proxy,omitempty"`
+	Transport  string            `json:"transport,omitempty"` // "" / "tcp" (default) or "quic"
 }
 
 // ── tls_config.json ──
 
 type TLSConfig struct {
+	Mode    string `json:"mode,omitempty"` // "" / "oneshot" (default) or "daemon"
 	Timeout struct {
 		Connect int `json:"connect"`
 		Read    int `json:"read"`
 	} `json:"timeout"`
-	Proxy struct {
-		Enabled bool   `json:"enabled"`
-		Type    string `json:"type"`
-		URL     string `json:"url"`
-	} `json:"proxy"`
-	DNS struct {
+	Proxy ProxyConfig `json:"proxy"`
+	DNS   struct {
 		Servers []string `json:"servers"`
 	} `json:"dns"`
-	Fingerprint FingerprintConfig `json:"fingerprint"`
+	Fingerprint     FingerprintConfig     `json:"fingerprint"`
+	QUICFingerprint QUICFingerprintConfig `json:"quic_fingerprint,omitempty"`
+	ShadowTLS       ShadowTLSConfig       `json:"shadowtls,omitempty"`
 }
 
 type FingerprintConfig struct {
+	Preset             string            `json:"preset,omitempty"`
+	JA3                string            `json:"ja3,omitempty"`
+	JA4                string            `json:"ja4,omitempty"`
 	TLSVersionMin      string            `json:"tls_version_min"`
 	TLSVersionMax      string            `json:"tls_version_max"`
 	HTTP2              bool              `json:"http2"`
+	HTTP2Settings      HTTP2Config       `json:"http2_settings,omitempty"`
 	GREASE             bool              `json:"grease"`
 	Ciphers            []string          `json:"ciphers"`
 	CompressionMethods []uint8           `json:"compression_methods"`
 	Extensions         []ExtensionConfig `json:"extensions"`
 }
 
+// HTTP2Config controls the SETTINGS frame values, pseudo-header order and
+// stream priority uTLS's caller uses when ALPN negotiates h2, so the h2
+// connection preamble matches a real browser's as closely as the TLS
+// ClientHello does.
+type HTTP2Config struct {
+	HeaderTableSize      *uint32  `json:"header_table_size,omitempty"`
+	InitialWindowSize    *uint32  `json:"initial_window_size,omitempty"`
+	MaxConcurrentStreams *uint32  `json:"max_concurrent_streams,omitempty"`
+	MaxHeaderListSize    *uint32  `json:"max_header_list_size,omitempty"`
+	ConnectionWindowSize uint32   `json:"connection_window_size,omitempty"`
+	PseudoHeaderOrder    []string `json:"pseudo_header_order,omitempty"`
+	PriorityStreamDep    uint32   `json:"priority_stream_dep,omitempty"`
+	PriorityWeight       uint8    `json:"priority_weight,omitempty"`
+	PriorityExclusive    bool     `json:"priority_exclusive,omitempty"`
+}
+
 type ExtensionConfig struct {
 	Name string          `json:"name"`
 	Data json.RawMessage `json:"data,omitempty"`
@@ -140,9 +169,13 @@ func fatal(msg string) {
 }
 
 func main() {
-	// 1. Read stdin
-	input, err := io.ReadAll(os.Stdin)
-	if err != nil {
+	// 1. Read the first request. A json.Decoder (rather than io.ReadAll) is
+	// used even here because daemon mode needs to keep reading subsequent
+	// requests off the same stdin stream.
+	dec := json.NewDecoder(os.Stdin)
+
+	var input json.RawMessage
+	if err := dec.Decode(&input); err != nil {
 		fatal("failed to read stdin: " + err.Error())
 	}
 
@@ -162,6 +195,18 @@ func main() {
 		fatal("invalid config JSON: " + err.Error())
 	}
 
+	if cfg.Mode == "daemon" {
+		runDaemon(dec, input, &req, &cfg)
+		return
+	}
+
+	runOneshot(input, &req, &cfg)
+}
+
+// runOneshot handles a single request and exits, which is the mode every
+// caller used before daemon mode existed: dial, handshake, issue the
+// request, stream the response to stdout, done.
+func runOneshot(input json.RawMessage, req *Request, cfg *TLSConfig) {
 	// 3. Parse target URL
 	u, err := url.Parse(req.URL)
 	if err != nil {
@@ -179,8 +224,12 @@ func main() {
 	}
 	addr := net.JoinHostPort(host, port)
 
-	// 4. Build ClientHelloSpec
-	spec := buildClientHelloSpec(&cfg.Fingerprint, host)
+	// 4. Build ClientHelloSpec: an explicit preset or JA3/JA4 string takes
+	// priority over the hand-written cipher/extension list.
+	spec, err := resolveFingerprintSpec(&cfg.Fingerprint, host)
+	if err != nil {
+		fatal("failed to resolve fingerprint: " + err.Error())
+	}
 
 	// 5. Establish TCP connection
 	connectTimeout := time.Duration(req.Timeout.Connect) * time.Second
@@ -192,42 +241,30 @@ func main() {
 		readTimeout = 120 * time.Second
 	}
 
-	var rawConn net.Conn
-
-	// Determine proxy: request-level overrides config-level
-	proxyEnabled := false
-	proxyType := ""
-	proxyURL := ""
-	if req.Proxy != nil && req.Proxy.Enabled {
-		proxyEnabled = true
-		proxyType = req.Proxy.Type
-		proxyURL = req.Proxy.URL
-	} else if cfg.Proxy.Enabled {
-		proxyEnabled = true
-		proxyType = cfg.Proxy.Type
-		proxyURL = cfg.Proxy.URL
+	// Determine proxy: request-level overrides config-level, pac_url (if
+	// any) resolved against the target URL.
+	proxyCfg, err := resolveProxy(req, cfg, req.URL)
+	if err != nil {
+		fatal("failed to resolve proxy: " + err.Error())
 	}
 
-	// Custom DNS resolver
-	resolver := &net.Resolver{
-		PreferGo: true,
-		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-			dnsServer := "8.8.8.8:53"
-			if len(cfg.DNS.Servers) > 0 {
-				dnsServer = cfg.DNS.Servers[0]
-			}
-			d := net.Dialer{Timeout: connectTimeout}
-			return d.DialContext(ctx, "udp", dnsServer)
-		},
-	}
-	dialer := &net.Dialer{
-		Timeout:  connectTimeout,
-		Resolver: resolver,
+	if req.Transport == "quic" {
+		if err := sendQUICRequest(req, spec, &cfg.QUICFingerprint, host, addr, parseOrderedHeaders(input), u, connectTimeout, readTimeout, os.Stdout, proxyCfg); err != nil {
+			fatal("quic request failed: " + err.Error())
+		}
+		return
 	}
 
-	if proxyEnabled {
-		rawConn, err = dialViaProxy(proxyType, proxyURL, addr, connectTimeout)
-	} else {
+	var rawConn net.Conn
+
+	dialer := newFingerprintDialer(cfg, connectTimeout)
+
+	switch {
+	case cfg.ShadowTLS.Enabled:
+		rawConn, err = dialShadowTLS(&cfg.ShadowTLS, spec, connectTimeout)
+	case proxyCfg != nil:
+		rawConn, err = dialViaProxy(proxyCfg, addr, connectTimeout)
+	default:
 		rawConn, err = dialer.Dial("tcp", addr)
 	}
 	if err != nil {
@@ -236,10 +273,14 @@ func main() {
 	defer rawConn.Close()
 
 	// 6. uTLS handshake
-	tlsConn := utls.UClient(rawConn, &utls.Config{
+	tlsConfig := &utls.Config{
 		ServerName:         host,
 		InsecureSkipVerify: false,
-	}, utls.HelloCustom)
+	}
+	if cfg.Fingerprint.HTTP2 {
+		tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+	}
+	tlsConn := utls.UClient(rawConn, tlsConfig, utls.HelloCustom)
 
 	if err := tlsConn.ApplyPreset(&spec); err != nil {
 		fatal("failed to apply TLS preset: " + err.Error())
@@ -247,20 +288,66 @@ func main() {
 
 	tlsConn.SetDeadline(time.Now().Add(connectTimeout))
 	if err := tlsConn.Handshake(); err != nil {
-		fatal("TLS handshake failed: " + err.Error())
+		retryList, isECHRequired := echRetryConfigs(err)
+		if !isECHRequired {
+			fatal("TLS handshake failed: " + err.Error())
+		}
+
+		// The server rejected our ECHConfig but sent retry_configs; the
+		// draft allows exactly one retry with the config it just gave us.
+		if applyErr := applyECHRetryConfigs(&spec, retryList, host); applyErr != nil {
+			fatal("TLS handshake failed: " + err.Error())
+		}
+		rawConn.Close()
+		switch {
+		case cfg.ShadowTLS.Enabled:
+			rawConn, err = dialShadowTLS(&cfg.ShadowTLS, spec, connectTimeout)
+		case proxyCfg != nil:
+			rawConn, err = dialViaProxy(proxyCfg, addr, connectTimeout)
+		default:
+			rawConn, err = dialer.Dial("tcp", addr)
+		}
+		if err != nil {
+			fatal("connection failed after ECH retry: " + err.Error())
+		}
+		defer rawConn.Close()
+
+		tlsConn = utls.UClient(rawConn, tlsConfig, utls.HelloCustom)
+		if err := tlsConn.ApplyPreset(&spec); err != nil {
+			fatal("failed to apply TLS preset after ECH retry: " + err.Error())
+		}
+		tlsConn.SetDeadline(time.Now().Add(connectTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			fatal("TLS handshake failed after ECH retry: " + err.Error())
+		}
 	}
 
-	// 7. Send HTTP request (manual construction to preserve header order)
 	tlsConn.SetDeadline(time.Now().Add(readTimeout))
 
+	orderedHeaders := parseOrderedHeaders(input)
+
+	// 7. Issue the request: h2 over the negotiated ALPN when requested,
+	// otherwise the existing manual HTTP/1.1 path.
+	if cfg.Fingerprint.HTTP2 && tlsConn.ConnectionState().NegotiatedProtocol == "h2" {
+		if err := sendHTTP2Request(tlsConn, req, &cfg.Fingerprint.HTTP2Settings, orderedHeaders, u, os.Stdout); err != nil {
+			fatal("http2 request failed: " + err.Error())
+		}
+		return
+	}
+
+	if err := sendHTTP1Request(tlsConn, req, orderedHeaders, u, os.Stdout); err != nil {
+		fatal(err.Error())
+	}
+}
+
+// sendHTTP1Request writes the request manually (to preserve header order)
+// and streams the response to w in the wire format callers expect.
+func sendHTTP1Request(tlsConn net.Conn, req *Request, orderedHeaders [][2]string, u *url.URL, w io.Writer) error {
 	path := u.RequestURI()
 	httpReq := fmt.Sprintf("%s %s HTTP/1.1\r\n", strings.ToUpper(req.Method), path)
 
-	// Write headers in the order provided by the caller
-	// Go maps don't preserve order, but the JSON decoder preserves order
-	// when unmarshaling into map[string]string via iteration order (Go 1.12+: random).
-	// We need to preserve the original order from JSON. Use a custom ordered approach.
-	orderedHeaders := parseOrderedHeaders(input)
+	// Write headers in the order provided by the caller (orderedHeaders was
+	// extracted from the raw JSON since map[string]string doesn't preserve it).
 	for _, kv := range orderedHeaders {
 		httpReq += fmt.Sprintf("%s: %s\r\n", kv[0], kv[1])
 	}
@@ -268,44 +355,45 @@ func main() {
 	httpReq += "\r\n"
 
 	if _, err := io.WriteString(tlsConn, httpReq); err != nil {
-		fatal("failed to write request headers: " + err.Error())
+		return fmt.Errorf("failed to write request headers: %w", err)
 	}
 
 	if req.Body != "" {
 		if _, err := io.WriteString(tlsConn, req.Body); err != nil {
-			fatal("failed to write request body: " + err.Error())
+			return fmt.Errorf("failed to write request body: %w", err)
 		}
 	}
 
-	// 8. Read and forward response to stdout
+	// 8. Read and forward response to w
 	reader := bufio.NewReader(tlsConn)
 
 	// Read status line
 	statusLine, err := reader.ReadString('\n')
 	if err != nil {
-		fatal("failed to read response status: " + err.Error())
+		return fmt.Errorf("failed to read response status: %w", err)
 	}
-	os.Stdout.WriteString(statusLine)
+	io.WriteString(w, statusLine)
 
 	// Read headers until empty line
 	for {
 		line, err := reader.ReadString('\n')
 		if err != nil {
-			fatal("failed to read response headers: " + err.Error())
+			return fmt.Errorf("failed to read response headers: %w", err)
 		}
-		os.Stdout.WriteString(line)
+		io.WriteString(w, line)
 		if line == "\r\n" || line == "\n" {
 			break
 		}
 	}
 
-	// Stream body to stdout
-	if _, err := io.Copy(os.Stdout, reader); err != nil {
+	// Stream body to w
+	if _, err := io.Copy(w, reader); err != nil {
 		// Connection may be closed by server after full response; ignore EOF
 		if err != io.EOF && !strings.Contains(err.Error(), "use of closed") {
-			// Non-fatal: response may already be complete
+			return fmt.Errorf("failed to read response body: %w", err)
 		}
 	}
+	return nil
 }
 
 // parseOrderedHeaders extracts headers from the raw JSON input preserving order.
@@ -348,7 +436,76 @@ func parseOrderedHeaders(raw []byte) [][2]string {
 	return result
 }
 
-func buildClientHelloSpec(fp *FingerprintConfig, serverName string) utls.ClientHelloSpec {
+// resolveFingerprintSpec picks the ClientHelloSpec source in priority
+// order: an explicit preset, then a JA3 string, then a JA4 string, falling
+// back to the hand-written cipher/extension list. Shared by the oneshot
+// and daemon request paths.
+func resolveFingerprintSpec(fp *FingerprintConfig, serverName string) (utls.ClientHelloSpec, error) {
+	switch {
+	case fp.Preset != "":
+		return specForPreset(fp.Preset)
+	case fp.JA3 != "":
+		return parseJA3(fp.JA3, serverName)
+	case fp.JA4 != "":
+		return parseJA4(fp.JA4, serverName)
+	default:
+		return buildClientHelloSpec(fp, serverName)
+	}
+}
+
+// resolveProxy applies the existing override rule (request-level proxy wins
+// over config-level), then - if the resolved config names a pac_url instead
+// of a fixed proxy - evaluates the PAC script against targetURL to pick one.
+// Returns nil, nil when no proxy applies - including a PAC result of
+// "DIRECT" - so every call site can use a plain nil check instead of also
+// having to know about ProxyConfig.Enabled. Shared by the oneshot and
+// daemon paths.
+func resolveProxy(req *Request, cfg *TLSConfig, targetURL string) (*ProxyConfig, error) {
+	pc := &cfg.Proxy
+	if req.Proxy != nil && req.Proxy.Enabled {
+		pc = req.Proxy
+	}
+	if !pc.Enabled {
+		return nil, nil
+	}
+	if pc.PACURL != "" {
+		resolved, err := resolvePAC(pc.PACURL, targetURL)
+		if err != nil {
+			return nil, err
+		}
+		if !resolved.Enabled {
+			return nil, nil
+		}
+		return resolved, nil
+	}
+	return pc, nil
+}
+
+// newFingerprintDialer builds the net.Dialer every direct-dial path uses.
+// It always resolves through a Go resolver (never the system resolver) that
+// queries cfg.DNS.Servers[0], or 8.8.8.8:53 when no server is configured,
+// so a custom DNS server configured once in
+// tls_config.json applies the same way whether the request runs through
+// runOneshot or daemon mode's executeRequest.
+func newFingerprintDialer(cfg *TLSConfig, connectTimeout time.Duration) *net.Dialer {
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dnsServer := "8.8.8.8:53"
+			if len(cfg.DNS.Servers) > 0 {
+				dnsServer = cfg.DNS.Servers[0]
+			}
+			d := net.Dialer{Timeout: connectTimeout}
+			return d.DialContext(ctx, "udp", dnsServer)
+		},
+	}
+	return &net.Dialer{
+		Timeout:  connectTimeout,
+		Resolver: resolver,
+	}
+}
+
+func buildClientHelloSpec(fp *FingerprintConfig, serverName string) (utls.ClientHelloSpec, error) {
 	// Cipher suites
 	var cipherSuites []uint16
 	for _, name := range fp.Ciphers {
@@ -370,7 +527,10 @@ func buildClientHelloSpec(fp *FingerprintConfig, serverName string) utls.ClientH
 	// Build extensions
 	var extensions []utls.TLSExtension
 	for _, ext := range fp.Extensions {
-		e := buildExtension(ext, fp, serverName)
+		e, err := buildExtension(ext, fp, serverName)
+		if err != nil {
+			return utls.ClientHelloSpec{}, err
+		}
 		if e != nil {
 			extensions = append(extensions, e)
 		}
@@ -382,30 +542,46 @@ func buildClientHelloSpec(fp *FingerprintConfig, serverName string) utls.ClientH
 		CipherSuites:       cipherSuites,
 		CompressionMethods: compressionMethods,
 		Extensions:         extensions,
-	}
+	}, nil
 }
 
-func buildExtension(ext ExtensionConfig, fp *FingerprintConfig, serverName string) utls.TLSExtension {
+func buildExtension(ext ExtensionConfig, fp *FingerprintConfig, serverName string) (utls.TLSExtension, error) {
 	switch ext.Name {
 	case "server_name":
-		return &utls.SNIExtension{ServerName: serverName}
+		return &utls.SNIExtension{ServerName: serverName}, nil
 
 	case "ec_point_formats":
 		return &utls.SupportedPointsExtension{
 			SupportedPoints: []byte{0}, // uncompressed
-		}
+		}, nil
 
 	case "renegotiation_info":
-		return &utls.RenegotiationInfoExtension{Renegotiation: utls.RenegotiateOnceAsClient}
+		return &utls.RenegotiationInfoExtension{Renegotiation: utls.RenegotiateOnceAsClient}, nil
 
 	case "extended_master_secret":
-		return &utls.ExtendedMasterSecretExtension{}
+		return &utls.ExtendedMasterSecretExtension{}, nil
 
 	case "signed_certificate_timestamp":
-		return &utls.SCTExtension{}
+		return &utls.SCTExtension{}, nil
 
 	case "status_request":
-		return &utls.StatusRequestExtension{}
+		return &utls.StatusRequestExtension{}, nil
+
+	case "application_layer_protocol_negotiation":
+		var data struct {
+			Protocols []string `json:"protocols"`
+		}
+		if ext.Data != nil {
+			json.Unmarshal(ext.Data, &data)
+		}
+		protocols := data.Protocols
+		if len(protocols) == 0 {
+			protocols = []string{"http/1.1"}
+			if fp.HTTP2 {
+				protocols = []string{"h2", "http/1.1"}
+			}
+		}
+		return &utls.ALPNExtension{AlpnProtocols: protocols}, nil
 
 	case "supported_groups":
 		var data struct {
@@ -420,7 +596,7 @@ func buildExtension(ext ExtensionConfig, fp *FingerprintConfig, serverName strin
 				groups = append(groups, id)
 			}
 		}
-		return &utls.SupportedCurvesExtension{Curves: groups}
+		return &utls.SupportedCurvesExtension{Curves: groups}, nil
 
 	case "signature_algorithms":
 		var data struct {
@@ -435,7 +611,7 @@ func buildExtension(ext ExtensionConfig, fp *FingerprintConfig, serverName strin
 				algs = append(algs, id)
 			}
 		}
-		return &utls.SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: algs}
+		return &utls.SignatureAlgorithmsExtension{SupportedSignatureAlgorithms: algs}, nil
 
 	case "signature_algorithms_cert":
 		var data struct {
@@ -450,7 +626,7 @@ func buildExtension(ext ExtensionConfig, fp *FingerprintConfig, serverName strin
 				algs = append(algs, id)
 			}
 		}
-		return &utls.SignatureAlgorithmsCertExtension{SupportedSignatureAlgorithms: algs}
+		return &utls.SignatureAlgorithmsCertExtension{SupportedSignatureAlgorithms: algs}, nil
 
 	case "supported_versions":
 		var data struct {
@@ -463,7 +639,7 @@ func buildExtension(ext ExtensionConfig, fp *FingerprintConfig, serverName strin
 		for _, v := range data.Versions {
 			versions = append(versions, parseTLSVersion(v))
 		}
-		return &utls.SupportedVersionsExtension{Versions: versions}
+		return &utls.SupportedVersionsExtension{Versions: versions}, nil
 
 	case "key_share":
 		var data struct {
@@ -478,89 +654,47 @@ func buildExtension(ext ExtensionConfig, fp *FingerprintConfig, serverName strin
 				keyShares = append(keyShares, utls.KeyShare{Group: id})
 			}
 		}
-		return &utls.KeyShareExtension{KeyShares: keyShares}
-
-	default:
-		return nil
-	}
-}
+		return &utls.KeyShareExtension{KeyShares: keyShares}, nil
 
-func dialViaProxy(proxyType, proxyURL, target string, timeout time.Duration) (net.Conn, error) {
-	switch strings.ToLower(proxyType) {
-	case "socks5", "socks":
-		return dialSocks5(proxyURL, target, timeout)
-	case "http", "https":
-		return dialHTTPProxy(proxyURL, target, timeout)
-	default:
-		return nil, fmt.Errorf("unsupported proxy type: %s", proxyType)
-	}
-}
-
-func dialSocks5(proxyURL, target string, timeout time.Duration) (net.Conn, error) {
-	u, err := url.Parse(proxyURL)
-	if err != nil {
-		return nil, fmt.Errorf("invalid proxy URL: %w", err)
-	}
+	case "encrypted_client_hello":
+		var data echExtensionData
+		if ext.Data != nil {
+			json.Unmarshal(ext.Data, &data)
+		}
+		if data.GREASE || (data.ConfigList == "" && !data.FetchDNS) {
+			return &utls.GREASEEncryptedClientHelloExtension{}, nil
+		}
+		// Unlike the no-config-source case above (a deliberate GREASE
+		// decoy), a config_list/fetch_dns was explicitly requested here to
+		// keep the real SNI encrypted - a bad base64 string, a DNS
+		// timeout, or an unparsable config failing silently into GREASE
+		// would send that SNI in the clear while reporting a normal
+		// successful request, so these errors propagate instead.
+		configList, err := resolveECHConfigList(data, serverName)
+		if err != nil {
+			return nil, fmt.Errorf("encrypted_client_hello: %w", err)
+		}
+		echCfg, err := parseECHConfigList(configList)
+		if err != nil {
+			return nil, fmt.Errorf("encrypted_client_hello: %w", err)
+		}
+		echExt, err := buildECHExtension(echCfg, serverName)
+		if err != nil {
+			return nil, fmt.Errorf("encrypted_client_hello: %w", err)
+		}
+		return echExt, nil
 
-	var auth *proxy.Auth
-	if u.User != nil {
-		pass, _ := u.User.Password()
-		auth = &proxy.Auth{
-			User:     u.User.Username(),
-			Password: pass,
+	case "ech_outer_extensions":
+		var data struct {
+			Extensions []string `json:"extensions"`
 		}
-	}
+		if ext.Data != nil {
+			json.Unmarshal(ext.Data, &data)
+		}
+		return buildECHOuterExtensions(data.Extensions), nil
 
-	dialer, err := proxy.SOCKS5("tcp", u.Host, auth, &net.Dialer{Timeout: timeout})
-	if err != nil {
-		return nil, fmt.Errorf("socks5 dialer failed: %w", err)
+	default:
+		return nil, nil
 	}
-
-	return dialer.Dial("tcp", target)
 }
 
-func dialHTTPProxy(proxyURL, target string, timeout time.Duration) (net.Conn, error) {
-	u, err := url.Parse(proxyURL)
-	if err != nil {
-		return nil, fmt.Errorf("invalid proxy URL: %w", err)
-	}
-
-	conn, err := net.DialTimeout("tcp", u.Host, timeout)
-	if err != nil {
-		return nil, fmt.Errorf("proxy connection failed: %w", err)
-	}
-
-	connectReq := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", target, target)
-	if u.User != nil {
-		// Basic auth not implemented for simplicity; add if needed
-	}
-	connectReq += "\r\n"
-
-	if _, err := io.WriteString(conn, connectReq); err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("proxy CONNECT write failed: %w", err)
-	}
-
-	// Read proxy response
-	br := bufio.NewReader(conn)
-	statusLine, err := br.ReadString('\n')
-	if err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("proxy CONNECT read failed: %w", err)
-	}
-
-	if !strings.Contains(statusLine, "200") {
-		conn.Close()
-		return nil, fmt.Errorf("proxy CONNECT rejected: %s", strings.TrimSpace(statusLine))
-	}
-
-	// Drain remaining headers
-	for {
-		line, err := br.ReadString('\n')
-		if err != nil || line == "\r\n" || line == "\n" {
-			break
-		}
-	}
-
-	return conn, nil
-}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// pacRuntime provides three of the PAC helper functions a FindProxyForURL
+// script expects to already be defined in its environment: dnsDomainIs,
+// shExpMatch and isPlainHostName. No other PAC helpers are defined.
+const pacRuntime = `
+function dnsDomainIs(host, domain) {
+	return host.length >= domain.length && host.substring(host.length - domain.length) === domain;
+}
+function shExpMatch(str, pattern) {
+	var re = new RegExp("^" + pattern.replace(/[.+^${}()|[\]\\]/g, "\\$&").replace(/\*/g, ".*").replace(/\?/g, ".") + "$");
+	return re.test(str);
+}
+function isPlainHostName(host) {
+	return host.indexOf(".") === -1;
+}
+`
+
+// resolvePAC fetches pacURL, evaluates its FindProxyForURL(url, host) for
+// targetURL, and turns the result ("DIRECT", "PROXY host:port",
+// "SOCKS5 host:port", ...) into a ProxyConfig.
+func resolvePAC(pacURL, targetURL string) (*ProxyConfig, error) {
+	script, err := fetchPACScript(pacURL)
+	if err != nil {
+		return nil, err
+	}
+
+	vm := goja.New()
+	if _, err := vm.RunString(pacRuntime + "\n" + script); err != nil {
+		return nil, fmt.Errorf("pac: script evaluation failed: %w", err)
+	}
+
+	findProxy, ok := goja.AssertFunction(vm.Get("FindProxyForURL"))
+	if !ok {
+		return nil, fmt.Errorf("pac: script has no FindProxyForURL function")
+	}
+
+	u, err := url.Parse(targetURL)
+	if err != nil {
+		return nil, fmt.Errorf("pac: invalid target URL: %w", err)
+	}
+
+	result, err := findProxy(goja.Undefined(), vm.ToValue(targetURL), vm.ToValue(u.Hostname()))
+	if err != nil {
+		return nil, fmt.Errorf("pac: FindProxyForURL call failed: %w", err)
+	}
+
+	return parsePACResult(result.String())
+}
+
+func fetchPACScript(pacURL string) (string, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(pacURL)
+	if err != nil {
+		return "", fmt.Errorf("pac: fetch failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("pac: read failed: %w", err)
+	}
+	return string(body), nil
+}
+
+// parsePACResult turns the first entry of FindProxyForURL's return value
+// into a ProxyConfig. The remaining entries (PAC's fallback list) are
+// ignored; there is no fallback to them when the first proxy fails.
+func parsePACResult(result string) (*ProxyConfig, error) {
+	entry := strings.TrimSpace(strings.Split(result, ";")[0])
+	if entry == "" || entry == "DIRECT" {
+		return &ProxyConfig{Enabled: false}, nil
+	}
+
+	fields := strings.Fields(entry)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("pac: unrecognized proxy entry %q", entry)
+	}
+
+	switch strings.ToUpper(fields[0]) {
+	case "PROXY", "HTTP":
+		return &ProxyConfig{Enabled: true, Type: "http", URL: "http://" + fields[1]}, nil
+	case "SOCKS", "SOCKS5":
+		return &ProxyConfig{Enabled: true, Type: "socks5", URL: "socks5://" + fields[1]}, nil
+	default:
+		return nil, fmt.Errorf("pac: unsupported proxy type %q", fields[0])
+	}
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dialViaProxy dials target through pc, which is either a single hop
+// (pc.Type/pc.URL) or an ordered Chain of hops each tunneling through the
+// previous one.
+func dialViaProxy(pc *ProxyConfig, target string, timeout time.Duration) (net.Conn, error) {
+	hops := pc.Chain
+	if len(hops) == 0 {
+		hops = []ProxyHop{{Type: pc.Type, URL: pc.URL}}
+	}
+	return dialProxyChain(hops, target, timeout)
+}
+
+// dialProxyChain dials hops[0] directly, then asks each hop in turn to
+// CONNECT to the next one (or, for the last hop, to target), so the
+// resulting conn is a tunnel through the whole chain.
+func dialProxyChain(hops []ProxyHop, target string, timeout time.Duration) (net.Conn, error) {
+	if len(hops) == 0 {
+		return nil, fmt.Errorf("no proxy hops configured")
+	}
+
+	firstURL, err := url.Parse(hops[0].URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+
+	conn, err := net.DialTimeout("tcp", firstURL.Host, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("proxy connection failed: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	for i, hop := range hops {
+		hopURL, err := url.Parse(hop.URL)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+
+		dest := target
+		if i+1 < len(hops) {
+			nextURL, err := url.Parse(hops[i+1].URL)
+			if err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("invalid proxy URL: %w", err)
+			}
+			dest = nextURL.Host
+		}
+
+		switch strings.ToLower(hop.Type) {
+		case "http", "https":
+			err = connectHTTP(conn, hopURL, dest)
+		case "socks5", "socks":
+			err = connectSocks5(conn, hopURL, dest)
+		default:
+			err = fmt.Errorf("unsupported proxy type in chain: %s", hop.Type)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// connectHTTP sends a CONNECT request for dest over conn (which may itself
+// already be tunneled through earlier hops), authenticating with hopURL's
+// userinfo if present.
+func connectHTTP(conn net.Conn, hopURL *url.URL, dest string) error {
+	connectReq := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", dest, dest)
+	if hopURL.User != nil {
+		pass, _ := hopURL.User.Password()
+		creds := base64.StdEncoding.EncodeToString([]byte(hopURL.User.Username() + ":" + pass))
+		connectReq += "Proxy-Authorization: Basic " + creds + "\r\n"
+	}
+	connectReq += "\r\n"
+
+	if _, err := io.WriteString(conn, connectReq); err != nil {
+		return fmt.Errorf("proxy CONNECT write failed: %w", err)
+	}
+
+	br := bufio.NewReader(conn)
+	statusLine, err := br.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("proxy CONNECT read failed: %w", err)
+	}
+	if !strings.Contains(statusLine, "200") {
+		return fmt.Errorf("proxy CONNECT rejected: %s", strings.TrimSpace(statusLine))
+	}
+
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil || line == "\r\n" || line == "\n" {
+			break
+		}
+	}
+	return nil
+}
+
+// connectSocks5 performs a SOCKS5 greeting (with username/password auth from
+// hopURL's userinfo when present) followed by a CONNECT request for dest,
+// over an already-established conn.
+func connectSocks5(conn net.Conn, hopURL *url.URL, dest string) error {
+	if err := socks5Greet(conn, hopURL); err != nil {
+		return err
+	}
+
+	host, portStr, err := net.SplitHostPort(dest)
+	if err != nil {
+		return fmt.Errorf("socks5: invalid destination %q: %w", dest, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fmt.Errorf("socks5: invalid port %q: %w", portStr, err)
+	}
+
+	req := []byte{0x05, 0x01, 0x00, 0x03, byte(len(host))}
+	req = append(req, host...)
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return fmt.Errorf("socks5: connect request write failed: %w", err)
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("socks5: connect response read failed: %w", err)
+	}
+	if header[1] != 0x00 {
+		return fmt.Errorf("socks5: connect request failed, code %d", header[1])
+	}
+
+	return discardSocks5BoundAddr(conn, header[3])
+}
+
+// socks5Greet performs the version/method negotiation and, if the server
+// demands it, username/password auth using hopURL's userinfo.
+func socks5Greet(conn net.Conn, hopURL *url.URL) error {
+	methods := []byte{0x00}
+	var username, password string
+	if hopURL.User != nil {
+		username = hopURL.User.Username()
+		password, _ = hopURL.User.Password()
+		methods = []byte{0x02, 0x00}
+	}
+
+	greeting := append([]byte{0x05, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return fmt.Errorf("socks5: greeting write failed: %w", err)
+	}
+
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return fmt.Errorf("socks5: greeting read failed: %w", err)
+	}
+	if resp[0] != 0x05 {
+		return fmt.Errorf("socks5: unexpected version %d", resp[0])
+	}
+
+	switch resp[1] {
+	case 0x00: // no auth required
+		return nil
+	case 0x02:
+		if username == "" {
+			return fmt.Errorf("socks5: server requires auth but no credentials configured")
+		}
+		authReq := []byte{0x01, byte(len(username))}
+		authReq = append(authReq, username...)
+		authReq = append(authReq, byte(len(password)))
+		authReq = append(authReq, password...)
+		if _, err := conn.Write(authReq); err != nil {
+			return fmt.Errorf("socks5: auth write failed: %w", err)
+		}
+		authResp := make([]byte, 2)
+		if _, err := io.ReadFull(conn, authResp); err != nil {
+			return fmt.Errorf("socks5: auth read failed: %w", err)
+		}
+		if authResp[1] != 0x00 {
+			return fmt.Errorf("socks5: authentication failed")
+		}
+		return nil
+	default:
+		return fmt.Errorf("socks5: no acceptable auth method (server wants %d)", resp[1])
+	}
+}
+
+// discardSocks5BoundAddr reads and discards the BND.ADDR/BND.PORT fields
+// that follow a SOCKS5 reply header; callers only need the connection, not
+// the bound address itself.
+func discardSocks5BoundAddr(conn net.Conn, atyp byte) error {
+	var addrLen int
+	switch atyp {
+	case 0x01:
+		addrLen = 4
+	case 0x04:
+		addrLen = 16
+	case 0x03:
+		lb := make([]byte, 1)
+		if _, err := io.ReadFull(conn, lb); err != nil {
+			return fmt.Errorf("socks5: response read failed: %w", err)
+		}
+		addrLen = int(lb[0])
+	default:
+		return fmt.Errorf("socks5: unknown address type %d", atyp)
+	}
+	if _, err := io.CopyN(io.Discard, conn, int64(addrLen+2)); err != nil {
+		return fmt.Errorf("socks5: response read failed: %w", err)
+	}
+	return nil
+}
+
+// socks5UDPConn is a UDP ASSOCIATE relay: datagrams written through it are
+// SOCKS5-framed and sent to the proxy's relay address, which forwards them
+// on to whatever destination each datagram names. The TCP control
+// connection from the ASSOCIATE request must stay open for the relay to
+// remain valid, so Close tears down both.
+type socks5UDPConn struct {
+	*net.UDPConn
+	ctrl net.Conn
+}
+
+// dialSocks5UDPAssociate performs a SOCKS5 UDP ASSOCIATE against hopURL,
+// giving callers (e.g. the QUIC transport) a PacketConn that relays
+// datagrams through the proxy instead of sending them directly.
+func dialSocks5UDPAssociate(hopURL *url.URL, timeout time.Duration) (*socks5UDPConn, error) {
+	ctrl, err := net.DialTimeout("tcp", hopURL.Host, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("socks5: udp associate dial failed: %w", err)
+	}
+
+	if err := socks5Greet(ctrl, hopURL); err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+
+	// The client's own UDP source is unknown up front, so associate with
+	// 0.0.0.0:0 and let the server tell us where to send datagrams.
+	req := []byte{0x05, 0x03, 0x00, 0x01, 0, 0, 0, 0, 0, 0}
+	if _, err := ctrl.Write(req); err != nil {
+		ctrl.Close()
+		return nil, fmt.Errorf("socks5: udp associate request failed: %w", err)
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(ctrl, header); err != nil {
+		ctrl.Close()
+		return nil, fmt.Errorf("socks5: udp associate response read failed: %w", err)
+	}
+	if header[1] != 0x00 {
+		ctrl.Close()
+		return nil, fmt.Errorf("socks5: udp associate rejected, code %d", header[1])
+	}
+
+	relayAddr, err := readSocks5BoundUDPAddr(ctrl, header[3])
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+
+	udpConn, err := net.DialUDP("udp", nil, relayAddr)
+	if err != nil {
+		ctrl.Close()
+		return nil, fmt.Errorf("socks5: udp relay dial failed: %w", err)
+	}
+
+	return &socks5UDPConn{UDPConn: udpConn, ctrl: ctrl}, nil
+}
+
+// readSocks5BoundUDPAddr reads the BND.ADDR/BND.PORT the server returned for
+// the relay and resolves it to a UDPAddr.
+func readSocks5BoundUDPAddr(conn net.Conn, atyp byte) (*net.UDPAddr, error) {
+	var ip net.IP
+	switch atyp {
+	case 0x01:
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, fmt.Errorf("socks5: udp relay address read failed: %w", err)
+		}
+		ip = net.IP(buf)
+	case 0x04:
+		buf := make([]byte, 16)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, fmt.Errorf("socks5: udp relay address read failed: %w", err)
+		}
+		ip = net.IP(buf)
+	case 0x03:
+		lb := make([]byte, 1)
+		if _, err := io.ReadFull(conn, lb); err != nil {
+			return nil, fmt.Errorf("socks5: udp relay address read failed: %w", err)
+		}
+		buf := make([]byte, int(lb[0]))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, fmt.Errorf("socks5: udp relay address read failed: %w", err)
+		}
+		resolved, err := net.ResolveIPAddr("ip", string(buf))
+		if err != nil {
+			return nil, fmt.Errorf("socks5: udp relay address resolve failed: %w", err)
+		}
+		ip = resolved.IP
+	default:
+		return nil, fmt.Errorf("socks5: unknown address type %d", atyp)
+	}
+
+	portBuf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, portBuf); err != nil {
+		return nil, fmt.Errorf("socks5: udp relay port read failed: %w", err)
+	}
+	port := int(portBuf[0])<<8 | int(portBuf[1])
+
+	return &net.UDPAddr{IP: ip, Port: port}, nil
+}
+
+// WriteDatagram SOCKS5-frames data (RSV+FRAG+ATYP+address+port header) for
+// destHost:destPort and sends it to the proxy's relay.
+func (c *socks5UDPConn) WriteDatagram(data []byte, destHost string, destPort int) (int, error) {
+	header := []byte{0x00, 0x00, 0x00, 0x03, byte(len(destHost))}
+	header = append(header, destHost...)
+	header = append(header, byte(destPort>>8), byte(destPort))
+	return c.UDPConn.Write(append(header, data...))
+}
+
+// WriteTo implements net.PacketConn for callers (uquic's PacketConn-based
+// QUIC dial) that address each outgoing packet by net.Addr rather than by
+// calling WriteDatagram directly: it SOCKS5-frames p for addr the same way
+// WriteDatagram does and sends it to the proxy's relay. Without this
+// override, the embedded *net.UDPConn's own promoted WriteTo would send p
+// unframed straight to the relay socket, which the proxy has no way to
+// forward anywhere.
+func (c *socks5UDPConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	host, portStr, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return 0, fmt.Errorf("socks5: invalid destination %q: %w", addr.String(), err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return 0, fmt.Errorf("socks5: invalid port %q: %w", portStr, err)
+	}
+	if _, err := c.WriteDatagram(p, host, port); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ReadDatagram reads one relayed datagram into buf, stripping the SOCKS5
+// framing header and returning just the payload length.
+func (c *socks5UDPConn) ReadDatagram(buf []byte) (int, error) {
+	packet := make([]byte, len(buf)+256) // framing overhead
+	n, err := c.UDPConn.Read(packet)
+	if err != nil {
+		return 0, err
+	}
+	if n < 4 {
+		return 0, fmt.Errorf("socks5: short udp relay packet")
+	}
+
+	atyp := packet[3]
+	var addrLen int
+	switch atyp {
+	case 0x01:
+		addrLen = 4
+	case 0x04:
+		addrLen = 16
+	case 0x03:
+		if n < 5 {
+			return 0, fmt.Errorf("socks5: short udp relay packet")
+		}
+		addrLen = int(packet[4])
+		addrLen++ // account for the length byte itself below
+	default:
+		return 0, fmt.Errorf("socks5: unknown address type %d in udp relay packet", atyp)
+	}
+
+	payloadStart := 4 + addrLen + 2 // + BND.PORT
+	if payloadStart > n {
+		return 0, fmt.Errorf("socks5: truncated udp relay packet")
+	}
+	return copy(buf, packet[payloadStart:n]), nil
+}
+
+// ReadFrom implements net.PacketConn for callers that read by net.Addr
+// rather than calling ReadDatagram directly: it strips the SOCKS5 relay
+// framing the same way ReadDatagram does. The reported sender address is
+// the relay's own address, since that's the only peer this socket is
+// actually connected to - the framed datagram's real source address isn't
+// surfaced here, matching ReadDatagram, which only returns the payload.
+func (c *socks5UDPConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, err := c.ReadDatagram(p)
+	if err != nil {
+		return 0, nil, err
+	}
+	return n, c.UDPConn.RemoteAddr(), nil
+}
+
+func (c *socks5UDPConn) Close() error {
+	c.ctrl.Close()
+	return c.UDPConn.Close()
+}
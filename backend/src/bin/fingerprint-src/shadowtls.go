@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"time"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// ShadowTLSConfig selects ShadowTLS v3 as the outbound connection mode: a
+// genuine TLS handshake with a decoy handshake_server, after which the real
+// request is tunneled through the same connection as authenticated
+// application-data records rather than sent directly.
+type ShadowTLSConfig struct {
+	Enabled         bool   `json:"enabled"`
+	Version         int    `json:"version"`
+	Password        string `json:"password"`
+	HandshakeServer string `json:"handshake_server"`
+	HandshakeSNI    string `json:"handshake_sni"`
+}
+
+const shadowTLSTagSize = 10 // truncated HMAC-SHA1 tag length used by the v3 protocol
+
+// withSNI returns a shallow copy of spec with its SNIExtension (if any)
+// replaced by one carrying serverName, leaving every other extension -
+// cipher suites, curves, ALPN, ordering - untouched so the rest of the
+// fingerprint is unaffected.
+func withSNI(spec utls.ClientHelloSpec, serverName string) utls.ClientHelloSpec {
+	out := spec
+	out.Extensions = make([]utls.TLSExtension, len(spec.Extensions))
+	copy(out.Extensions, spec.Extensions)
+	for i, ext := range out.Extensions {
+		if _, ok := ext.(*utls.SNIExtension); ok {
+			out.Extensions[i] = &utls.SNIExtension{ServerName: serverName}
+		}
+	}
+	return out
+}
+
+// dialShadowTLS performs a genuine TLS handshake with the decoy
+// handshake_server, using the same uTLS ClientHelloSpec the real target
+// would otherwise get so the outer handshake is just as JA3-accurate, then
+// wraps the resulting connection so every subsequent Write/Read is framed
+// and HMAC-SHA1 authenticated as a ShadowTLS v3 application-data record.
+// The server-side proxy verifies the HMAC to tell an authenticated client
+// from the decoy traffic it just relays, and forwards authenticated
+// records to the real backend - which is where the caller's own uTLS
+// handshake to the true target then happens, tunneled through this conn.
+func dialShadowTLS(stCfg *ShadowTLSConfig, spec utls.ClientHelloSpec, connectTimeout time.Duration) (net.Conn, error) {
+	if stCfg.Version != 3 {
+		return nil, fmt.Errorf("unsupported shadowtls version: %d (only v3 is implemented)", stCfg.Version)
+	}
+
+	rawConn, err := net.DialTimeout("tcp", stCfg.HandshakeServer, connectTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("shadowtls: dial handshake server failed: %w", err)
+	}
+
+	sni := stCfg.HandshakeSNI
+	if sni == "" {
+		if host, _, splitErr := net.SplitHostPort(stCfg.HandshakeServer); splitErr == nil {
+			sni = host
+		} else {
+			sni = stCfg.HandshakeServer
+		}
+	}
+
+	// utls.Config.ServerName only governs certificate-hostname verification;
+	// what actually goes out on the wire in the ClientHello's SNI extension
+	// is whatever SNIExtension sits in spec.Extensions, which was built
+	// against the real target host. The decoy handshake has to present the
+	// handshake_server's own SNI on the wire too, or it's trivially
+	// distinguishable from a real visit to that server.
+	decoySpec := withSNI(spec, sni)
+
+	tlsConn := utls.UClient(rawConn, &utls.Config{ServerName: sni, InsecureSkipVerify: false}, utls.HelloCustom)
+	if err := tlsConn.ApplyPreset(&decoySpec); err != nil {
+		rawConn.Close()
+		return nil, fmt.Errorf("shadowtls: failed to apply TLS preset: %w", err)
+	}
+
+	tlsConn.SetDeadline(time.Now().Add(connectTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		rawConn.Close()
+		return nil, fmt.Errorf("shadowtls: decoy handshake failed: %w", err)
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	return &shadowTLSConn{Conn: tlsConn, mac: hmac.New(sha1.New, []byte(stCfg.Password))}, nil
+}
+
+// shadowTLSConn wraps a completed decoy TLS connection and frames every
+// Write as a ShadowTLS v3 application_data record: a standard 5-byte TLS
+// record header (0x17 0x03 0x03 + length) followed by the payload and then
+// a truncated HMAC-SHA1 tag over header+payload.
+type shadowTLSConn struct {
+	net.Conn
+	mac     hash.Hash
+	readBuf []byte
+}
+
+func (c *shadowTLSConn) Write(p []byte) (int, error) {
+	header := []byte{0x17, 0x03, 0x03, byte(len(p) >> 8), byte(len(p))}
+
+	c.mac.Reset()
+	c.mac.Write(header)
+	c.mac.Write(p)
+	tag := c.mac.Sum(nil)[:shadowTLSTagSize]
+
+	record := make([]byte, 0, len(header)+len(p)+len(tag))
+	record = append(record, header...)
+	record = append(record, p...)
+	record = append(record, tag...)
+
+	if _, err := c.Conn.Write(record); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *shadowTLSConn) Read(p []byte) (int, error) {
+	for len(c.readBuf) == 0 {
+		header := make([]byte, 5)
+		if _, err := io.ReadFull(c.Conn, header); err != nil {
+			return 0, err
+		}
+		length := int(header[3])<<8 | int(header[4])
+
+		body := make([]byte, length+shadowTLSTagSize)
+		if _, err := io.ReadFull(c.Conn, body); err != nil {
+			return 0, err
+		}
+		payload, tag := body[:length], body[length:]
+
+		c.mac.Reset()
+		c.mac.Write(header)
+		c.mac.Write(payload)
+		expected := c.mac.Sum(nil)[:shadowTLSTagSize]
+		if !hmac.Equal(tag, expected) {
+			return 0, fmt.Errorf("shadowtls: record authentication failed")
+		}
+
+		c.readBuf = payload
+	}
+
+	n := copy(p, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}